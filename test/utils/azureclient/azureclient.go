@@ -0,0 +1,183 @@
+package azureclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+
+	"github.com/kubernetes-csi/csi-driver-smb/test/utils/credentials"
+)
+
+// AzureClient wraps the storage account and file share ARM clients
+type AzureClient struct {
+	resourceGroup    string
+	location         string
+	accountsClient   *armstorage.AccountsClient
+	fileSharesClient *armstorage.FileSharesClient
+}
+
+// NewAzureClient authenticates against creds.Cloud using the mode selected by creds.AuthMode and
+// returns an AzureClient scoped to creds.ResourceGroup and creds.Location.
+func NewAzureClient(creds *credentials.Credentials) (*AzureClient, error) {
+	cloudConfig, err := cloudConfigurationFor(creds)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving cloud configuration for %q: %v", creds.Cloud, err)
+	}
+
+	cred, err := newTokenCredential(creds, cloudConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating azure credential: %v", err)
+	}
+
+	clientOptions := arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: cloudConfig}}
+
+	accountsClient, err := armstorage.NewAccountsClient(creds.SubscriptionID, cred, &clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error creating storage accounts client: %v", err)
+	}
+
+	fileSharesClient, err := armstorage.NewFileSharesClient(creds.SubscriptionID, cred, &clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file shares client: %v", err)
+	}
+
+	return &AzureClient{
+		resourceGroup:    creds.ResourceGroup,
+		location:         creds.Location,
+		accountsClient:   accountsClient,
+		fileSharesClient: fileSharesClient,
+	}, nil
+}
+
+// cloudConfigurationFor resolves creds.Cloud to an azcore cloud.Configuration, reading
+// creds.AzureEnvironmentFilepath for clouds with no built-in SDK configuration (AzureGermanCloud,
+// AzureStackCloud, or any other sovereign cloud).
+func cloudConfigurationFor(creds *credentials.Credentials) (cloud.Configuration, error) {
+	switch creds.Cloud {
+	case "", credentials.AzurePublicCloud:
+		return cloud.AzurePublic, nil
+	case credentials.AzureChinaCloud:
+		return cloud.AzureChina, nil
+	case credentials.AzureUSGovernmentCloud:
+		return cloud.AzureGovernment, nil
+	default:
+		if creds.AzureEnvironmentFilepath == "" {
+			return cloud.Configuration{}, fmt.Errorf("AzureEnvironmentFilepath must be set for cloud %q", creds.Cloud)
+		}
+
+		env, err := credentials.ParseAzureEnvironmentConfig(creds.AzureEnvironmentFilepath)
+		if err != nil {
+			return cloud.Configuration{}, err
+		}
+
+		return cloud.Configuration{
+			ActiveDirectoryAuthorityHost: env.ActiveDirectoryEndpoint,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {
+					Endpoint: env.ResourceManagerEndpoint,
+					Audience: env.ResourceManagerEndpoint,
+				},
+			},
+		}, nil
+	}
+}
+
+// newTokenCredential builds the azcore.TokenCredential matching creds.AuthMode against cloudConfig,
+// falling back to azidentity.NewDefaultAzureCredential when creds selects no explicit mode.
+// AuthModeAzureCLI ignores cloudConfig and uses whatever cloud the az CLI is logged in to.
+func newTokenCredential(creds *credentials.Credentials, cloudConfig cloud.Configuration) (azcore.TokenCredential, error) {
+	clientOptions := azcore.ClientOptions{Cloud: cloudConfig}
+
+	switch creds.AuthMode {
+	case credentials.AuthModeClientSecret:
+		return azidentity.NewClientSecretCredential(creds.TenantID, creds.AADClientID, creds.AADClientSecret, &azidentity.ClientSecretCredentialOptions{
+			ClientOptions: clientOptions,
+		})
+	case credentials.AuthModeManagedIdentity:
+		opts := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if creds.UserAssignedIdentityID != "" {
+			opts.ID = azidentity.ClientID(creds.UserAssignedIdentityID)
+		}
+		return azidentity.NewManagedIdentityCredential(opts)
+	case credentials.AuthModeWorkloadIdentity:
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			ClientID:      creds.AADClientID,
+			TenantID:      creds.TenantID,
+			TokenFilePath: creds.AADFederatedTokenFile,
+		})
+	case credentials.AuthModeClientCertificate:
+		certData, err := os.ReadFile(creds.AADClientCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client certificate %s: %v", creds.AADClientCertPath, err)
+		}
+		certs, key, err := azidentity.ParseCertificates(certData, []byte(creds.AADClientCertPassword))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing client certificate %s: %v", creds.AADClientCertPath, err)
+		}
+		return azidentity.NewClientCertificateCredential(creds.TenantID, creds.AADClientID, certs, key, &azidentity.ClientCertificateCredentialOptions{
+			ClientOptions: clientOptions,
+		})
+	case credentials.AuthModeAzureCLI:
+		return azidentity.NewAzureCLICredential(nil)
+	default:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions})
+	}
+}
+
+// CreateStorageAccount creates a Standard_LRS StorageV2 account named accountName
+func (c *AzureClient) CreateStorageAccount(ctx context.Context, accountName string) error {
+	poller, err := c.accountsClient.BeginCreate(ctx, c.resourceGroup, accountName, armstorage.AccountCreateParameters{
+		SKU:      &armstorage.SKU{Name: to.Ptr(armstorage.SKUNameStandardLRS)},
+		Kind:     to.Ptr(armstorage.KindStorageV2),
+		Location: to.Ptr(c.location),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error creating storage account %s: %v", accountName, err)
+	}
+
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("error waiting for storage account %s to be created: %v", accountName, err)
+	}
+
+	return nil
+}
+
+// DeleteStorageAccount deletes the storage account named accountName
+func (c *AzureClient) DeleteStorageAccount(ctx context.Context, accountName string) error {
+	if _, err := c.accountsClient.Delete(ctx, c.resourceGroup, accountName, nil); err != nil {
+		return fmt.Errorf("error deleting storage account %s: %v", accountName, err)
+	}
+
+	return nil
+}
+
+// GetStorageAccountKey returns the first access key of the storage account named accountName
+func (c *AzureClient) GetStorageAccountKey(ctx context.Context, accountName string) (string, error) {
+	resp, err := c.accountsClient.ListKeys(ctx, c.resourceGroup, accountName, nil)
+	if err != nil {
+		return "", fmt.Errorf("error listing keys for storage account %s: %v", accountName, err)
+	}
+
+	if len(resp.Keys) == 0 || resp.Keys[0].Value == nil {
+		return "", fmt.Errorf("storage account %s has no access keys", accountName)
+	}
+
+	return *resp.Keys[0].Value, nil
+}
+
+// CreateFileShare creates an SMB file share named shareName in the storage account accountName
+func (c *AzureClient) CreateFileShare(ctx context.Context, accountName, shareName string) error {
+	if _, err := c.fileSharesClient.Create(ctx, c.resourceGroup, accountName, shareName, armstorage.FileShare{}, nil); err != nil {
+		return fmt.Errorf("error creating file share %s in storage account %s: %v", shareName, accountName, err)
+	}
+
+	return nil
+}
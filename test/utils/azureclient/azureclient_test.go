@@ -0,0 +1,90 @@
+package azureclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-csi/csi-driver-smb/test/utils/credentials"
+)
+
+func TestCloudConfigurationFor(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "azurestackcloud.json")
+	require.NoError(t, os.WriteFile(envFile, []byte(`{
+		"name": "AzureStackCloud",
+		"resourceManagerEndpoint": "https://management.local.azurestack.external/",
+		"activeDirectoryEndpoint": "https://login.local.azurestack.external/"
+	}`), 0644))
+
+	tests := []struct {
+		desc      string
+		creds     credentials.Credentials
+		expected  string
+		expectErr bool
+	}{
+		{desc: "empty cloud defaults to public", creds: credentials.Credentials{}, expected: cloud.AzurePublic.ActiveDirectoryAuthorityHost},
+		{desc: "azure public cloud", creds: credentials.Credentials{Cloud: credentials.AzurePublicCloud}, expected: cloud.AzurePublic.ActiveDirectoryAuthorityHost},
+		{desc: "azure china cloud", creds: credentials.Credentials{Cloud: credentials.AzureChinaCloud}, expected: cloud.AzureChina.ActiveDirectoryAuthorityHost},
+		{desc: "azure us government cloud", creds: credentials.Credentials{Cloud: credentials.AzureUSGovernmentCloud}, expected: cloud.AzureGovernment.ActiveDirectoryAuthorityHost},
+		{
+			desc:      "sovereign cloud without environment filepath",
+			creds:     credentials.Credentials{Cloud: credentials.AzureStackCloud},
+			expectErr: true,
+		},
+		{
+			desc:     "sovereign cloud with environment filepath",
+			creds:    credentials.Credentials{Cloud: credentials.AzureStackCloud, AzureEnvironmentFilepath: envFile},
+			expected: "https://login.local.azurestack.external/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cloudConfig, err := cloudConfigurationFor(&test.creds)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cloudConfig.ActiveDirectoryAuthorityHost)
+		})
+	}
+}
+
+func TestNewTokenCredential(t *testing.T) {
+	tests := []struct {
+		desc      string
+		creds     credentials.Credentials
+		expectErr bool
+	}{
+		{desc: "client secret", creds: credentials.Credentials{AuthMode: credentials.AuthModeClientSecret, TenantID: "tenant-id", AADClientID: "id", AADClientSecret: "secret"}},
+		{desc: "managed identity", creds: credentials.Credentials{AuthMode: credentials.AuthModeManagedIdentity}},
+		{desc: "managed identity with user assigned identity", creds: credentials.Credentials{AuthMode: credentials.AuthModeManagedIdentity, UserAssignedIdentityID: "client-id"}},
+		{desc: "workload identity", creds: credentials.Credentials{AuthMode: credentials.AuthModeWorkloadIdentity, TenantID: "tenant-id", AADClientID: "id", AADFederatedTokenFile: "/var/run/secrets/token"}},
+		{desc: "azure cli", creds: credentials.Credentials{AuthMode: credentials.AuthModeAzureCLI}},
+		{desc: "unset auth mode falls back to default credential", creds: credentials.Credentials{}},
+		{
+			desc:      "client certificate missing file",
+			creds:     credentials.Credentials{AuthMode: credentials.AuthModeClientCertificate, AADClientID: "id", AADClientCertPath: "/tmp/does-not-exist.pfx"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cred, err := newTokenCredential(&test.creds, cloud.AzurePublic)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotNil(t, cred)
+		})
+	}
+}
@@ -1,10 +1,11 @@
 package credentials
 
 import (
+	"encoding/json"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	"github.com/pborman/uuid"
 	"github.com/pelletier/go-toml"
@@ -12,24 +13,41 @@ import (
 	"k8s.io/klog"
 )
 
+// AuthMode describes how the Azure credential file authenticates against Azure Active Directory.
+type AuthMode string
+
 const (
 	AzurePublicCloud            = "AzurePublicCloud"
 	AzureChinaCloud             = "AzureChinaCloud"
+	AzureUSGovernmentCloud      = "AzureUSGovernmentCloud"
+	AzureGermanCloud            = "AzureGermanCloud"
+	AzureStackCloud             = "AzureStackCloud"
 	TempAzureCredentialFilePath = "/tmp/azure.json"
 
-	azureCredentialFileTemplate = `{
-    "cloud": "{{.Cloud}}",
-    "tenantId": "{{.TenantID}}",
-    "subscriptionId": "{{.SubscriptionID}}",
-    "aadClientId": "{{.AADClientID}}",
-    "aadClientSecret": "{{.AADClientSecret}}",
-    "resourceGroup": "{{.ResourceGroup}}",
-    "location": "{{.Location}}"
-}`
+	// AuthModeClientSecret authenticates with a long-lived AAD client secret (the default, legacy behavior).
+	AuthModeClientSecret AuthMode = "client-secret"
+	// AuthModeManagedIdentity authenticates using the VM/VMSS managed identity extension.
+	AuthModeManagedIdentity AuthMode = "msi"
+	// AuthModeWorkloadIdentity authenticates using AAD workload identity federation (a projected service account token).
+	AuthModeWorkloadIdentity AuthMode = "workload-identity"
+	// AuthModeClientCertificate authenticates with an AAD client certificate.
+	AuthModeClientCertificate AuthMode = "client-certificate"
+	// AuthModeAzureCLI authenticates by reusing the token cached by an `az login` session.
+	AuthModeAzureCLI AuthMode = "azure-cli"
+
 	defaultAzurePublicCloudLocation = "eastus2"
 	defaultAzureChinaCloudLocation  = "chinaeast2"
 )
 
+// cloudEnvSuffix maps a cloud name to the suffix used to look up its env vars,
+// e.g. tenantId_china for AzureChinaCloud. AzurePublicCloud uses no suffix.
+var cloudEnvSuffix = map[string]string{
+	AzureChinaCloud:        "_china",
+	AzureUSGovernmentCloud: "_usgovernment",
+	AzureGermanCloud:       "_german",
+	AzureStackCloud:        "_azurestack",
+}
+
 // CredentialsConfig is used in Prow to store Azure credentials
 // https://github.com/kubernetes/test-infra/blob/master/kubetest/utils/azure.go#L116-L118
 type CredentialsConfig struct {
@@ -49,52 +67,163 @@ type CredentialsFromProw struct {
 
 // Credentials is used in Azure File CSI Driver to store Azure credentials
 type Credentials struct {
-	Cloud           string
-	TenantID        string
-	SubscriptionID  string
+	Cloud          string
+	TenantID       string
+	SubscriptionID string
+	ResourceGroup  string
+	Location       string
+
+	AuthMode AuthMode
+
+	// AADClientID and AADClientSecret are used by AuthModeClientSecret.
 	AADClientID     string
 	AADClientSecret string
-	ResourceGroup   string
-	Location        string
+
+	// UseManagedIdentityExtension and UserAssignedIdentityID are used by AuthModeManagedIdentity.
+	UseManagedIdentityExtension bool
+	UserAssignedIdentityID      string
+
+	// AADFederatedTokenFile is used by AuthModeWorkloadIdentity, together with AADClientID.
+	AADFederatedTokenFile string
+
+	// AADClientCertPath and AADClientCertPassword are used by AuthModeClientCertificate, together with AADClientID.
+	AADClientCertPath     string
+	AADClientCertPassword string
+
+	// AzureEnvironmentFilepath points at a cloud-environment JSON describing a sovereign or
+	// Azure Stack cloud's endpoints. Required when Cloud is AzureStackCloud.
+	AzureEnvironmentFilepath string
+}
+
+// cloudProviderAzureConfig mirrors the subset of the cloud-provider-azure config schema that the
+// Azure credential file needs
+type cloudProviderAzureConfig struct {
+	Cloud          string `json:"cloud"`
+	TenantID       string `json:"tenantId"`
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceGroup  string `json:"resourceGroup"`
+	Location       string `json:"location"`
+
+	AADClientID     string `json:"aadClientId,omitempty"`
+	AADClientSecret string `json:"aadClientSecret,omitempty"`
+
+	UseManagedIdentityExtension bool   `json:"useManagedIdentityExtension,omitempty"`
+	UserAssignedIdentityID      string `json:"userAssignedIdentityID,omitempty"`
+
+	UseFederatedWorkloadIdentityExtension bool   `json:"useFederatedWorkloadIdentityExtension,omitempty"`
+	AADFederatedTokenFile                 string `json:"aadFederatedTokenFile,omitempty"`
+
+	AADClientCertPath     string `json:"aadClientCertPath,omitempty"`
+	AADClientCertPassword string `json:"aadClientCertPassword,omitempty"`
+
+	UseAzureCLI bool `json:"useAzureCLI,omitempty"`
+
+	AzureEnvironmentFilepath string `json:"azureEnvironmentFilepath,omitempty"`
+}
+
+// toCloudProviderAzureConfig projects c onto the fields cloud-provider-azure actually reads
+func toCloudProviderAzureConfig(c Credentials) cloudProviderAzureConfig {
+	return cloudProviderAzureConfig{
+		Cloud:                                 c.Cloud,
+		TenantID:                              c.TenantID,
+		SubscriptionID:                        c.SubscriptionID,
+		ResourceGroup:                         c.ResourceGroup,
+		Location:                              c.Location,
+		AADClientID:                           c.AADClientID,
+		AADClientSecret:                       c.AADClientSecret,
+		UseManagedIdentityExtension:           c.UseManagedIdentityExtension,
+		UserAssignedIdentityID:                c.UserAssignedIdentityID,
+		UseFederatedWorkloadIdentityExtension: c.AuthMode == AuthModeWorkloadIdentity,
+		AADFederatedTokenFile:                 c.AADFederatedTokenFile,
+		AADClientCertPath:                     c.AADClientCertPath,
+		AADClientCertPassword:                 c.AADClientCertPassword,
+		UseAzureCLI:                           c.AuthMode == AuthModeAzureCLI,
+		AzureEnvironmentFilepath:              c.AzureEnvironmentFilepath,
+	}
+}
+
+// inferAuthMode recovers the AuthMode of a Credentials parsed back from a credential file
+func inferAuthMode(c *Credentials) AuthMode {
+	switch {
+	case c.UseManagedIdentityExtension:
+		return AuthModeManagedIdentity
+	case c.AADFederatedTokenFile != "":
+		return AuthModeWorkloadIdentity
+	case c.AADClientCertPath != "":
+		return AuthModeClientCertificate
+	case c.AADClientSecret == "":
+		return AuthModeAzureCLI
+	default:
+		return AuthModeClientSecret
+	}
+}
+
+// AzureEnvironmentConfig describes the endpoints of a sovereign or Azure Stack cloud
+type AzureEnvironmentConfig struct {
+	Name                    string `json:"name"`
+	ResourceManagerEndpoint string `json:"resourceManagerEndpoint"`
+	ActiveDirectoryEndpoint string `json:"activeDirectoryEndpoint"`
+	StorageEndpointSuffix   string `json:"storageEndpointSuffix"`
+}
+
+// ParseAzureEnvironmentConfig reads and unmarshals the cloud-environment JSON at path
+func ParseAzureEnvironmentConfig(path string) (*AzureEnvironmentConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading azure environment config %v %v", path, err)
+	}
+
+	var env AzureEnvironmentConfig
+	if err := json.Unmarshal(content, &env); err != nil {
+		return nil, fmt.Errorf("error parsing azure environment config %v %v", path, err)
+	}
+
+	return &env, nil
 }
 
 // CreateAzureCredentialFile creates a temporary Azure credential file for
-// Azure File CSI driver tests and returns the credentials
-func CreateAzureCredentialFile(isAzureChinaCloud bool) (*Credentials, error) {
-	// Search credentials through env vars first
-	var cloud, tenantId, subscriptionId, aadClientId, aadClientSecret, resourceGroup, location string
-	if isAzureChinaCloud {
-		cloud = AzureChinaCloud
-		tenantId = os.Getenv("tenantId_china")
-		subscriptionId = os.Getenv("subscriptionId_china")
-		aadClientId = os.Getenv("aadClientId_china")
-		aadClientSecret = os.Getenv("aadClientSecret_china")
-		resourceGroup = os.Getenv("resourceGroup_china")
-		location = os.Getenv("location_china")
-	} else {
+// Azure File CSI driver tests targeting cloud and returns the credentials.
+// cloud must be one of AzurePublicCloud, AzureChinaCloud, AzureUSGovernmentCloud,
+// AzureGermanCloud or AzureStackCloud; pass "" to default to AzurePublicCloud.
+func CreateAzureCredentialFile(cloud string) (*Credentials, error) {
+	if cloud == "" {
 		cloud = AzurePublicCloud
-		tenantId = os.Getenv("tenantId")
-		subscriptionId = os.Getenv("subscriptionId")
-		aadClientId = os.Getenv("aadClientId")
-		aadClientSecret = os.Getenv("aadClientSecret")
-		resourceGroup = os.Getenv("resourceGroup")
-		location = os.Getenv("location")
 	}
 
+	suffix := cloudEnvSuffix[cloud]
+
+	// Search credentials through env vars first
+	tenantId := os.Getenv("tenantId" + suffix)
+	subscriptionId := os.Getenv("subscriptionId" + suffix)
+	aadClientId := os.Getenv("aadClientId" + suffix)
+	aadClientSecret := os.Getenv("aadClientSecret" + suffix)
+	resourceGroup := os.Getenv("resourceGroup" + suffix)
+	location := os.Getenv("location" + suffix)
+	azureEnvironmentFilepath := os.Getenv("AZURE_ENVIRONMENT_FILEPATH" + suffix)
+
 	if resourceGroup == "" {
 		resourceGroup = "azurefile-csi-driver-test-" + uuid.NewUUID().String()
 	}
 
 	if location == "" {
-		if isAzureChinaCloud {
+		switch cloud {
+		case AzureChinaCloud:
 			location = defaultAzureChinaCloudLocation
-		} else {
+		case AzurePublicCloud:
 			location = defaultAzurePublicCloudLocation
+		default:
+			return nil, fmt.Errorf("location%s must be set when cloud is %s", suffix, cloud)
 		}
 	}
 
-	if tenantId != "" && subscriptionId != "" && aadClientId != "" && aadClientSecret != "" {
-		return parseAndExecuteTemplate(cloud, tenantId, subscriptionId, aadClientId, aadClientSecret, resourceGroup, location)
+	if cloud == AzureStackCloud && azureEnvironmentFilepath == "" {
+		return nil, fmt.Errorf("AZURE_ENVIRONMENT_FILEPATH%s must be set when cloud is %s", suffix, AzureStackCloud)
+	}
+
+	authMode := getAuthMode()
+
+	if authMode != AuthModeClientSecret || (tenantId != "" && subscriptionId != "" && aadClientId != "" && aadClientSecret != "") {
+		return writeAzureCredentialFile(authMode, cloud, tenantId, subscriptionId, aadClientId, aadClientSecret, resourceGroup, location, azureEnvironmentFilepath)
 	}
 
 	// If the tests are being run on Prow, credentials are not supplied through env vars. Instead, it is supplied
@@ -107,12 +236,48 @@ func CreateAzureCredentialFile(isAzureChinaCloud bool) (*Credentials, error) {
 			return nil, err
 		}
 		// We only test on AzurePublicCloud in Prow
-		return parseAndExecuteTemplate(cloud, c.TenantID, c.SubscriptionID, c.ClientID, c.ClientSecret, resourceGroup, location)
+		return writeAzureCredentialFile(authMode, cloud, c.TenantID, c.SubscriptionID, c.ClientID, c.ClientSecret, resourceGroup, location, azureEnvironmentFilepath)
 	}
 
 	return nil, fmt.Errorf("AZURE_CREDENTIALS is not set. You will need to set the following env vars: $tenantId, $subscriptionId, $aadClientId and $aadClientSecret")
 }
 
+// ParseAzureCredentialFile loads and unmarshals the Azure credential file at TempAzureCredentialFilePath
+func ParseAzureCredentialFile() (*Credentials, error) {
+	return ParseAzureCredentialFileAtPath(TempAzureCredentialFilePath)
+}
+
+// ParseAzureCredentialFileAtPath loads and unmarshals the Azure credential file at path
+func ParseAzureCredentialFileAtPath(path string) (*Credentials, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credential file %v %v", path, err)
+	}
+
+	var c Credentials
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("error parsing credential file %v %v", path, err)
+	}
+	c.AuthMode = inferAuthMode(&c)
+
+	return &c, nil
+}
+
+var (
+	credentialsOnce   sync.Once
+	cachedCredentials *Credentials
+	cachedCredErr     error
+)
+
+// GetCredentials returns the cached Credentials, parsing TempAzureCredentialFilePath on first use
+func GetCredentials() (*Credentials, error) {
+	credentialsOnce.Do(func() {
+		cachedCredentials, cachedCredErr = ParseAzureCredentialFile()
+	})
+
+	return cachedCredentials, cachedCredErr
+}
+
 // CreateAzureCredentialFile deletes the temporary Azure credential file
 func DeleteAzureCredentialFile() error {
 	if err := os.Remove(TempAzureCredentialFilePath); err != nil && !os.IsNotExist(err) {
@@ -122,6 +287,59 @@ func DeleteAzureCredentialFile() error {
 	return nil
 }
 
+// getAuthMode reads the desired AuthMode from the authMode/AZURE_AUTH_MODE env vars,
+// defaulting to AuthModeClientSecret when unset.
+func getAuthMode() AuthMode {
+	mode := os.Getenv("authMode")
+	if mode == "" {
+		mode = os.Getenv("AZURE_AUTH_MODE")
+	}
+
+	switch AuthMode(mode) {
+	case AuthModeManagedIdentity, AuthModeWorkloadIdentity, AuthModeClientCertificate, AuthModeAzureCLI:
+		return AuthMode(mode)
+	default:
+		return AuthModeClientSecret
+	}
+}
+
+// validateAuthMode checks that only the fields relevant to authMode are populated,
+// rejecting credentials that mix fields from mutually-exclusive auth modes.
+func validateAuthMode(c *Credentials) error {
+	switch c.AuthMode {
+	case AuthModeClientSecret:
+		if c.AADFederatedTokenFile != "" || c.AADClientCertPath != "" || c.UseManagedIdentityExtension {
+			return fmt.Errorf("authMode %q cannot be combined with workload identity, client certificate or managed identity fields", c.AuthMode)
+		}
+	case AuthModeManagedIdentity:
+		if c.AADClientSecret != "" || c.AADFederatedTokenFile != "" || c.AADClientCertPath != "" {
+			return fmt.Errorf("authMode %q cannot be combined with client secret, workload identity or client certificate fields", c.AuthMode)
+		}
+	case AuthModeWorkloadIdentity:
+		if c.AADClientID == "" || c.AADFederatedTokenFile == "" {
+			return fmt.Errorf("authMode %q requires AADClientID and AADFederatedTokenFile to be set", c.AuthMode)
+		}
+		if c.AADClientSecret != "" || c.AADClientCertPath != "" || c.UseManagedIdentityExtension {
+			return fmt.Errorf("authMode %q cannot be combined with client secret, client certificate or managed identity fields", c.AuthMode)
+		}
+	case AuthModeClientCertificate:
+		if c.AADClientID == "" || c.AADClientCertPath == "" {
+			return fmt.Errorf("authMode %q requires AADClientID and AADClientCertPath to be set", c.AuthMode)
+		}
+		if c.AADClientSecret != "" || c.AADFederatedTokenFile != "" || c.UseManagedIdentityExtension {
+			return fmt.Errorf("authMode %q cannot be combined with client secret, workload identity or managed identity fields", c.AuthMode)
+		}
+	case AuthModeAzureCLI:
+		if c.AADClientSecret != "" || c.AADFederatedTokenFile != "" || c.AADClientCertPath != "" || c.UseManagedIdentityExtension {
+			return fmt.Errorf("authMode %q cannot be combined with any AAD client credential fields", c.AuthMode)
+		}
+	default:
+		return fmt.Errorf("unknown authMode %q", c.AuthMode)
+	}
+
+	return nil
+}
+
 // getCredentialsFromAzureCredentials parses the azure credentials toml (AZURE_CREDENTIALS)
 // in Prow and return the credential information usable to Azure File CSI driver
 func getCredentialsFromAzureCredentials(azureCredentialsPath string) (*CredentialsFromProw, error) {
@@ -139,32 +357,36 @@ func getCredentialsFromAzureCredentials(azureCredentialsPath string) (*Credentia
 	return &c.Creds, nil
 }
 
-// parseAndExecuteTemplate replaces credential placeholders in hack/template/azure.json with actual credentials
-func parseAndExecuteTemplate(cloud, tenantId, subscriptionId, aadClientId, aadClientSecret, resourceGroup, location string) (*Credentials, error) {
-	t := template.New("AzureCredentialFileTemplate")
-	t, err := t.Parse(azureCredentialFileTemplate)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing  azureCredentialFileTemplate %v", err)
+// writeAzureCredentialFile writes the cloud-provider-azure credential file for authMode
+func writeAzureCredentialFile(authMode AuthMode, cloud, tenantId, subscriptionId, aadClientId, aadClientSecret, resourceGroup, location, azureEnvironmentFilepath string) (*Credentials, error) {
+	c := Credentials{
+		Cloud:                       cloud,
+		TenantID:                    tenantId,
+		SubscriptionID:              subscriptionId,
+		ResourceGroup:               resourceGroup,
+		Location:                    location,
+		AuthMode:                    authMode,
+		AADClientID:                 aadClientId,
+		AADClientSecret:             aadClientSecret,
+		UseManagedIdentityExtension: authMode == AuthModeManagedIdentity,
+		UserAssignedIdentityID:      os.Getenv("userAssignedIdentityID"),
+		AADFederatedTokenFile:       os.Getenv("AADFederatedTokenFile"),
+		AADClientCertPath:           os.Getenv("aadClientCertPath"),
+		AADClientCertPassword:       os.Getenv("aadClientCertPassword"),
+		AzureEnvironmentFilepath:    azureEnvironmentFilepath,
 	}
 
-	f, err := os.Create(TempAzureCredentialFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("error creating %s %v", TempAzureCredentialFilePath, err)
+	if err := validateAuthMode(&c); err != nil {
+		return nil, fmt.Errorf("invalid credentials for authMode %q: %v", authMode, err)
 	}
-	defer f.Close()
 
-	c := Credentials{
-		cloud,
-		tenantId,
-		subscriptionId,
-		aadClientId,
-		aadClientSecret,
-		resourceGroup,
-		location,
-	}
-	err = t.Execute(f, c)
+	data, err := json.MarshalIndent(toCloudProviderAzureConfig(c), "", "    ")
 	if err != nil {
-		return nil, fmt.Errorf("error executing parsed azure credential file tempalte %v", err)
+		return nil, fmt.Errorf("error marshaling azure credential file %v", err)
+	}
+
+	if err := ioutil.WriteFile(TempAzureCredentialFilePath, data, 0644); err != nil {
+		return nil, fmt.Errorf("error writing %s %v", TempAzureCredentialFilePath, err)
 	}
 
 	return &c, nil
@@ -0,0 +1,152 @@
+package credentials
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAuthMode(t *testing.T) {
+	tests := []struct {
+		desc     string
+		authMode string
+		envVar   string
+		expected AuthMode
+	}{
+		{desc: "unset defaults to client secret", expected: AuthModeClientSecret},
+		{desc: "authMode msi", authMode: "msi", expected: AuthModeManagedIdentity},
+		{desc: "AZURE_AUTH_MODE workload-identity", envVar: "workload-identity", expected: AuthModeWorkloadIdentity},
+		{desc: "authMode client-certificate", authMode: "client-certificate", expected: AuthModeClientCertificate},
+		{desc: "authMode azure-cli", authMode: "azure-cli", expected: AuthModeAzureCLI},
+		{desc: "unknown value defaults to client secret", authMode: "bogus", expected: AuthModeClientSecret},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if test.authMode != "" {
+				t.Setenv("authMode", test.authMode)
+			}
+			if test.envVar != "" {
+				t.Setenv("AZURE_AUTH_MODE", test.envVar)
+			}
+
+			assert.Equal(t, test.expected, getAuthMode())
+		})
+	}
+}
+
+func TestValidateAuthMode(t *testing.T) {
+	tests := []struct {
+		desc      string
+		creds     Credentials
+		expectErr bool
+	}{
+		{desc: "client secret valid", creds: Credentials{AuthMode: AuthModeClientSecret, AADClientID: "id", AADClientSecret: "secret"}},
+		{
+			desc:      "client secret mixed with client certificate fields",
+			creds:     Credentials{AuthMode: AuthModeClientSecret, AADClientCertPath: "/tmp/cert.pfx"},
+			expectErr: true,
+		},
+		{desc: "managed identity valid", creds: Credentials{AuthMode: AuthModeManagedIdentity, UseManagedIdentityExtension: true}},
+		{
+			desc:      "managed identity mixed with client secret",
+			creds:     Credentials{AuthMode: AuthModeManagedIdentity, UseManagedIdentityExtension: true, AADClientSecret: "secret"},
+			expectErr: true,
+		},
+		{
+			desc:  "workload identity valid",
+			creds: Credentials{AuthMode: AuthModeWorkloadIdentity, AADClientID: "id", AADFederatedTokenFile: "/var/run/token"},
+		},
+		{
+			desc:      "workload identity missing AADClientID",
+			creds:     Credentials{AuthMode: AuthModeWorkloadIdentity, AADFederatedTokenFile: "/var/run/token"},
+			expectErr: true,
+		},
+		{
+			desc:      "workload identity missing AADFederatedTokenFile",
+			creds:     Credentials{AuthMode: AuthModeWorkloadIdentity, AADClientID: "id"},
+			expectErr: true,
+		},
+		{
+			desc:  "client certificate valid",
+			creds: Credentials{AuthMode: AuthModeClientCertificate, AADClientID: "id", AADClientCertPath: "/tmp/cert.pfx"},
+		},
+		{
+			desc:      "client certificate missing path",
+			creds:     Credentials{AuthMode: AuthModeClientCertificate, AADClientID: "id"},
+			expectErr: true,
+		},
+		{
+			desc:      "client certificate missing AADClientID",
+			creds:     Credentials{AuthMode: AuthModeClientCertificate, AADClientCertPath: "/tmp/cert.pfx"},
+			expectErr: true,
+		},
+		{desc: "azure cli valid", creds: Credentials{AuthMode: AuthModeAzureCLI}},
+		{
+			desc:      "azure cli mixed with client secret",
+			creds:     Credentials{AuthMode: AuthModeAzureCLI, AADClientSecret: "secret"},
+			expectErr: true,
+		},
+		{desc: "unknown auth mode", creds: Credentials{AuthMode: AuthMode("bogus")}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := validateAuthMode(&test.creds)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWriteAndParseAzureCredentialFile(t *testing.T) {
+	tests := []struct {
+		desc            string
+		authMode        AuthMode
+		aadClientId     string
+		aadClientSecret string
+	}{
+		{desc: "client secret", authMode: AuthModeClientSecret, aadClientId: "client-id", aadClientSecret: "client-secret"},
+		{desc: "managed identity", authMode: AuthModeManagedIdentity},
+		{desc: "workload identity", authMode: AuthModeWorkloadIdentity, aadClientId: "client-id"},
+		{desc: "client certificate", authMode: AuthModeClientCertificate, aadClientId: "client-id"},
+		{desc: "azure cli", authMode: AuthModeAzureCLI},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if test.authMode == AuthModeWorkloadIdentity {
+				t.Setenv("AADFederatedTokenFile", "/var/run/secrets/token")
+			}
+			if test.authMode == AuthModeClientCertificate {
+				t.Setenv("aadClientCertPath", "/tmp/cert.pfx")
+			}
+
+			path := TempAzureCredentialFilePath
+			_, err := writeAzureCredentialFile(test.authMode, AzurePublicCloud, "tenant-id", "sub-id", test.aadClientId, test.aadClientSecret, "test-rg", "eastus2", "")
+			require.NoError(t, err)
+			defer func() { _ = DeleteAzureCredentialFile() }()
+
+			parsed, err := ParseAzureCredentialFileAtPath(path)
+			require.NoError(t, err)
+			assert.Equal(t, test.authMode, parsed.AuthMode)
+			assert.Equal(t, "tenant-id", parsed.TenantID)
+			assert.Equal(t, "sub-id", parsed.SubscriptionID)
+		})
+	}
+}
+
+func TestCreateAzureCredentialFileAzureStackRequiresLocation(t *testing.T) {
+	_, err := CreateAzureCredentialFile(AzureStackCloud)
+	require.Error(t, err)
+}
+
+func TestCreateAzureCredentialFileAzureStackRequiresEnvironmentFilepath(t *testing.T) {
+	t.Setenv("location_azurestack", "local")
+	_, err := CreateAzureCredentialFile(AzureStackCloud)
+	require.Error(t, err)
+}